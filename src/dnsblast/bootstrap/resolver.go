@@ -0,0 +1,171 @@
+// Package bootstrap resolves NS records and upstream hostnames through an
+// explicit bootstrap DNS server instead of the host's default resolver, so
+// that picking a DoT/DoH/DoQ target doesn't leak through the user's ISP (and
+// still works when the target's own NS records live behind DoT/DoH/DoQ
+// hostnames the system resolver can't reach).
+package bootstrap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultAddress is used when the caller doesn't configure one explicitly.
+const DefaultAddress = "8.8.8.8:53"
+
+const (
+	dialTimeout  = 1 * time.Second
+	queryTimeout = 2 * time.Second
+)
+
+// Resolver resolves names via a single bootstrap nameserver, bypassing
+// net.DefaultResolver entirely. Results are cached in-memory, honoring each
+// record's own TTL, and the cache is safe to share across goroutines.
+type Resolver struct {
+	address string
+	client  *dns.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	addrs     []string
+	expiresAt time.Time
+}
+
+// NewResolver builds a Resolver that queries address (e.g. "8.8.8.8:53") for
+// everything. An empty address falls back to DefaultAddress.
+func NewResolver(address string) *Resolver {
+	if address == "" {
+		address = DefaultAddress
+	}
+
+	return &Resolver{
+		address: address,
+		client: &dns.Client{
+			Net:          "udp",
+			DialTimeout:  dialTimeout,
+			WriteTimeout: queryTimeout,
+			ReadTimeout:  queryTimeout,
+		},
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+// LookupNS resolves the nameservers for domain via the bootstrap server,
+// mirroring net.LookupNS but without touching the system resolver.
+func (r *Resolver) LookupNS(domain string) ([]string, error) {
+	if cached, ok := r.fromCache("ns|" + domain); ok {
+		return cached, nil
+	}
+
+	msg := new(dns.Msg).SetQuestion(dns.Fqdn(domain), dns.TypeNS)
+
+	reply, _, err := r.client.Exchange(msg, r.address)
+	if err != nil {
+		return nil, fmt.Errorf("bootstrap NS query failed [domain=%s; bootstrap=%s]: %w", domain, r.address, err)
+	}
+
+	var (
+		hosts  []string
+		minTTL = uint32(0)
+	)
+
+	for _, rr := range reply.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		hosts = append(hosts, ns.Ns)
+		if minTTL == 0 || rr.Header().Ttl < minTTL {
+			minTTL = rr.Header().Ttl
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no NS records for [domain=%s] via bootstrap [bootstrap=%s]", domain, r.address)
+	}
+
+	r.toCache("ns|"+domain, hosts, minTTL)
+
+	return hosts, nil
+}
+
+// LookupHost resolves the A/AAAA addresses of host via the bootstrap server,
+// used to connect to DoT/DoH/DoQ endpoints addressed by hostname.
+func (r *Resolver) LookupHost(host string) ([]string, error) {
+	if cached, ok := r.fromCache("a|" + host); ok {
+		return cached, nil
+	}
+
+	var (
+		addrs  []string
+		minTTL = uint32(0)
+	)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg).SetQuestion(dns.Fqdn(host), qtype)
+
+		reply, _, err := r.client.Exchange(msg, r.address)
+		if err != nil {
+			return nil, fmt.Errorf("bootstrap A/AAAA query failed [host=%s; bootstrap=%s]: %w", host, r.address, err)
+		}
+
+		for _, rr := range reply.Answer {
+			var ip string
+
+			switch record := rr.(type) {
+			case *dns.A:
+				ip = record.A.String()
+			case *dns.AAAA:
+				ip = record.AAAA.String()
+			default:
+				continue
+			}
+
+			addrs = append(addrs, ip)
+			if minTTL == 0 || rr.Header().Ttl < minTTL {
+				minTTL = rr.Header().Ttl
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no A/AAAA records for [host=%s] via bootstrap [bootstrap=%s]", host, r.address)
+	}
+
+	r.toCache("a|"+host, addrs, minTTL)
+
+	return addrs, nil
+}
+
+func (r *Resolver) fromCache(key string) ([]string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.addrs, true
+}
+
+func (r *Resolver) toCache(key string, addrs []string, ttlSeconds uint32) {
+	if ttlSeconds == 0 {
+		ttlSeconds = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cache[key] = cacheEntry{
+		addrs:     addrs,
+		expiresAt: time.Now().Add(time.Duration(ttlSeconds) * time.Second),
+	}
+}
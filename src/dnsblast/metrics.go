@@ -0,0 +1,147 @@
+package dnsblast
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MetricsSink receives one record per sampled query (see Config.SampleRate),
+// so operators can tell a degraded target apart from one that's silently
+// dropping packets instead of just watching throughput.
+type MetricsSink interface {
+	// RecordQuery is called once per sampled query. err is the transport
+	// error, if any; rcode/truncated are only meaningful when err is nil.
+	RecordQuery(nameserver, proto string, qtype uint16, rtt time.Duration, rcode int, truncated bool, err error)
+}
+
+// rttHistogramBuckets spans a typical resolver's fast path (low
+// milliseconds) through a badly degraded one (multiple seconds).
+var rttHistogramBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// PrometheusMetricsSink is the default MetricsSink, registering its
+// collectors under the "dnsblast_" namespace.
+type PrometheusMetricsSink struct {
+	queriesSent          *prometheus.CounterVec
+	queriesAcked         *prometheus.CounterVec
+	rtt                  *prometheus.HistogramVec
+	rcodeCount           *prometheus.CounterVec
+	truncatedCount       *prometheus.CounterVec
+	tlsHandshakeFailures *prometheus.CounterVec
+	timeouts             *prometheus.CounterVec
+}
+
+// NewPrometheusMetricsSink registers its collectors against registerer (pass
+// prometheus.DefaultRegisterer to hook into the global /metrics endpoint).
+func NewPrometheusMetricsSink(registerer prometheus.Registerer) *PrometheusMetricsSink {
+	factory := promauto.With(registerer)
+
+	return &PrometheusMetricsSink{
+		queriesSent: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "queries_sent_total",
+			Help:      "DNS queries sent, per nameserver and protocol.",
+		}, []string{"nameserver", "proto"}),
+		queriesAcked: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "queries_acked_total",
+			Help:      "DNS queries that received a response, per nameserver and protocol.",
+		}, []string{"nameserver", "proto"}),
+		rtt: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "dnsblast",
+			Name:      "query_rtt_seconds",
+			Help:      "Round-trip time of acknowledged queries, per nameserver and protocol.",
+			Buckets:   rttHistogramBuckets,
+		}, []string{"nameserver", "proto"}),
+		rcodeCount: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "response_rcode_total",
+			Help:      "Responses received, by RCODE, per nameserver and protocol.",
+		}, []string{"nameserver", "proto", "rcode"}),
+		truncatedCount: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "response_truncated_total",
+			Help:      "Responses received with the TC flag set, per nameserver and protocol.",
+		}, []string{"nameserver", "proto"}),
+		tlsHandshakeFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "tls_handshake_failures_total",
+			Help:      "Query failures attributable to a TLS handshake, per nameserver and protocol.",
+		}, []string{"nameserver", "proto"}),
+		timeouts: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dnsblast",
+			Name:      "query_timeouts_total",
+			Help:      "Query failures attributable to a timeout, per nameserver and protocol.",
+		}, []string{"nameserver", "proto"}),
+	}
+}
+
+func (s *PrometheusMetricsSink) RecordQuery(nameserver, proto string, _ uint16, rtt time.Duration, rcode int, truncated bool, err error) {
+	s.queriesSent.WithLabelValues(nameserver, proto).Inc()
+
+	if err != nil {
+		switch classifyQueryError(err) {
+		case errClassTimeout:
+			s.timeouts.WithLabelValues(nameserver, proto).Inc()
+		case errClassTLSHandshake:
+			s.tlsHandshakeFailures.WithLabelValues(nameserver, proto).Inc()
+		}
+
+		return
+	}
+
+	s.queriesAcked.WithLabelValues(nameserver, proto).Inc()
+	s.rtt.WithLabelValues(nameserver, proto).Observe(rtt.Seconds())
+	s.rcodeCount.WithLabelValues(nameserver, proto, strconv.Itoa(rcode)).Inc()
+
+	if truncated {
+		s.truncatedCount.WithLabelValues(nameserver, proto).Inc()
+	}
+}
+
+type errClass int
+
+const (
+	errClassOther errClass = iota
+	errClassTimeout
+	errClassTLSHandshake
+)
+
+// classifyQueryError distinguishes a timed-out dial/read/write from a TLS
+// handshake failure so operators aren't left guessing which one dominates.
+func classifyQueryError(err error) errClass {
+	var netErr net.Error
+	if ok := asNetError(err, &netErr); ok && netErr.Timeout() {
+		return errClassTimeout
+	}
+
+	if strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "handshake") {
+		return errClassTLSHandshake
+	}
+
+	return errClassOther
+}
+
+func asNetError(err error, target *net.Error) bool {
+	for err != nil {
+		if netErr, ok := err.(net.Error); ok {
+			*target = netErr
+			return true
+		}
+
+		unwrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+
+		err = unwrapper.Unwrap()
+	}
+
+	return false
+}
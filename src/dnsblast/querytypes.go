@@ -0,0 +1,78 @@
+package dnsblast
+
+import (
+	"math/rand"
+
+	"github.com/miekg/dns"
+)
+
+// QueryTypeWeight associates a DNS query type with how often it should be
+// sampled relative to the other entries in a Config.QueryTypes list.
+type QueryTypeWeight struct {
+	Type   uint16
+	Weight int
+}
+
+// defaultQueryTypeWeights is used when Config.QueryTypes is empty. A and AAAA
+// make up half of the draws; MX, TXT, NS and SOA a tenth each; DNSKEY and ANY,
+// the types with the largest answers, a twentieth each.
+var defaultQueryTypeWeights = []QueryTypeWeight{
+	{Type: dns.TypeA, Weight: 30},
+	{Type: dns.TypeAAAA, Weight: 20},
+	{Type: dns.TypeMX, Weight: 10},
+	{Type: dns.TypeTXT, Weight: 10},
+	{Type: dns.TypeNS, Weight: 10},
+	{Type: dns.TypeSOA, Weight: 10},
+	{Type: dns.TypeDNSKEY, Weight: 5},
+	{Type: dns.TypeANY, Weight: 5},
+}
+
+// queryTypeSampler draws a query type from a weighted distribution using a
+// cumulative-weight table, so higher-weighted types are proportionally more
+// likely without needing a fresh shuffle per draw.
+type queryTypeSampler struct {
+	types      []uint16
+	cumulative []int
+	total      int
+}
+
+func newQueryTypeSampler(weights []QueryTypeWeight) *queryTypeSampler {
+	if len(weights) == 0 {
+		weights = defaultQueryTypeWeights
+	}
+
+	s := &queryTypeSampler{
+		types:      make([]uint16, 0, len(weights)),
+		cumulative: make([]int, 0, len(weights)),
+	}
+
+	for _, w := range weights {
+		if w.Weight <= 0 {
+			continue
+		}
+
+		s.total += w.Weight
+		s.types = append(s.types, w.Type)
+		s.cumulative = append(s.cumulative, s.total)
+	}
+
+	return s
+}
+
+// sample returns a query type drawn from the weighted distribution, or
+// dns.TypeA if every configured weight was non-positive.
+func (s *queryTypeSampler) sample() uint16 {
+	if s.total == 0 {
+		return dns.TypeA
+	}
+
+	pick := rand.Intn(s.total) //nolint:gosec // not security sensitive, just load distribution
+
+	for i, boundary := range s.cumulative {
+		if pick < boundary {
+			return s.types[i]
+		}
+	}
+
+	return s.types[len(s.types)-1]
+}
@@ -0,0 +1,32 @@
+package dnsblast
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Arriven/db1000n/src/dnsblast/bootstrap"
+)
+
+// resolveHostAndPort resolves the host part of hostAndPort through resolver
+// when it's a hostname rather than an IP literal, so DoT/DoH/DoQ upstreams
+// addressed by hostname don't fall back to the system resolver. It returns
+// the dialable "ip:port" alongside the original hostname, which callers still
+// need for TLS ServerName / HTTP Host header purposes. A nil resolver or an
+// already-literal host is returned unchanged.
+func resolveHostAndPort(resolver *bootstrap.Resolver, hostAndPort string) (dialAddress string, sniHost string, err error) {
+	host, port, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to split host/port [address=%s]: %w", hostAndPort, err)
+	}
+
+	if resolver == nil || net.ParseIP(host) != nil {
+		return hostAndPort, host, nil
+	}
+
+	addrs, err := resolver.LookupHost(host)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve upstream host via bootstrap resolver [host=%s]: %w", host, err)
+	}
+
+	return net.JoinHostPort(addrs[0], port), host, nil
+}
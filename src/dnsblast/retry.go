@@ -0,0 +1,71 @@
+package dnsblast
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// disableTCPRetryEnvVar lets operators kill the TCP-retry-on-truncation
+// behavior without touching the config file, e.g. for a quick rollback.
+const disableTCPRetryEnvVar = "DB1000N_DNS_DISABLE_TCP_RETRY"
+
+// maxConcurrentTCPRetries bounds how many of a dnsUpstream's parallel workers
+// may be blocked retrying over TCP at once, so a server that truncates every
+// answer can't make every worker pile onto slow TCP connections at the same
+// time.
+const maxConcurrentTCPRetries = 32
+
+// envDisablesTCPRetry reports whether the DB1000N_DNS_DISABLE_TCP_RETRY
+// environment variable opts out of the TCP retry, on top of whatever the
+// config says.
+func envDisablesTCPRetry() bool {
+	value, ok := os.LookupEnv(disableTCPRetryEnvVar)
+	return ok && value != "" && value != "0" && value != "false"
+}
+
+// tcpRetrier lazily builds a single TCP *dns.Client per nameserver, shared
+// across the goroutines blasting that nameserver, and bounds how many of
+// them may be retrying over TCP concurrently.
+type tcpRetrier struct {
+	hostAndPort string
+	disabled    bool
+
+	once   sync.Once
+	client *dns.Client
+	sem    chan struct{}
+}
+
+func newTCPRetrier(hostAndPort string, disabled bool) *tcpRetrier {
+	return &tcpRetrier{
+		hostAndPort: hostAndPort,
+		disabled:    disabled || envDisablesTCPRetry(),
+		sem:         make(chan struct{}, maxConcurrentTCPRetries),
+	}
+}
+
+// retryIfTruncated re-issues msg over TCP when reply has the TC flag set,
+// following the same behavior Tailscale's DNS forwarder uses for truncated
+// upstream UDP answers. It returns the original (reply, rtt, err) unchanged
+// when no retry is needed or the retrier is disabled.
+func (r *tcpRetrier) retryIfTruncated(msg *dns.Msg, reply *dns.Msg, rtt time.Duration, err error) (*dns.Msg, time.Duration, error) {
+	if r.disabled || err != nil || reply == nil || !reply.Truncated {
+		return reply, rtt, err
+	}
+
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+
+	r.once.Do(func() {
+		r.client = &dns.Client{
+			Net:          TCPProtoName,
+			DialTimeout:  dialTimeout,
+			WriteTimeout: writeTimeout,
+			ReadTimeout:  readTimeout,
+		}
+	})
+
+	return r.client.Exchange(msg, r.hostAndPort)
+}
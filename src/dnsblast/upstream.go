@@ -0,0 +1,157 @@
+package dnsblast
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Arriven/db1000n/src/dnsblast/bootstrap"
+	"github.com/miekg/dns"
+	utls "github.com/refraction-networking/utls"
+)
+
+// Upstream is the protocol-agnostic contract ExecuteStressTest blasts
+// against. Picking a concrete implementation is the factory's job
+// (NewUpstream); nothing in the blast loop itself needs to know or care
+// whether it's talking UDP, DoT, DoH or DoQ.
+type Upstream interface {
+	Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error)
+	Address() string
+	Close() error
+}
+
+// NewUpstream builds an Upstream from a URL-style address, mirroring the
+// dnsproxy/AdGuard convention: "udp://host:53", "tcp://host:53",
+// "tls://host:853", "https://host:443/dns-query", "quic://host:853". A bare
+// "host:port" with no scheme is treated as plain UDP for backwards
+// compatibility with existing configs. resolver may be nil, in which case
+// hostnames are resolved through the system resolver as before; DoT/DoH/DoQ
+// targets addressed by hostname should pass a bootstrap.Resolver so the
+// lookup doesn't leak through the system resolver.
+func NewUpstream(ctx context.Context, rawAddress string, enableHTTP3 bool, dohMethod string, resolver *bootstrap.Resolver, disableTCPRetryOnTruncation bool) (Upstream, error) {
+	parsed, err := url.Parse(rawAddress)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return newDNSUpstream(UDPProtoName, rawAddress, nil, disableTCPRetryOnTruncation)
+	}
+
+	switch parsed.Scheme {
+	case UDPProtoName:
+		return newDNSUpstream(UDPProtoName, parsed.Host, nil, disableTCPRetryOnTruncation)
+	case TCPProtoName:
+		return newDNSUpstream(TCPProtoName, parsed.Host, nil, disableTCPRetryOnTruncation)
+	case "tls":
+		return newDNSUpstream(TCPTLSProtoName, parsed.Host, resolver, disableTCPRetryOnTruncation)
+	case "https":
+		client, err := newDoHClient(rawAddress, enableHTTP3, dohMethod, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up DoH upstream [address=%s]: %w", rawAddress, err)
+		}
+
+		return client, nil
+	case "quic":
+		client, err := newDoQClient(ctx, parsed.Host, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up DoQ upstream [address=%s]: %w", rawAddress, err)
+		}
+
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme [address=%s]: %q", rawAddress, parsed.Scheme)
+	}
+}
+
+// dnsUpstream implements Upstream for the udp/tcp/tcp-tls transports that
+// the github.com/miekg/dns client already supports natively.
+type dnsUpstream struct {
+	proto       string
+	hostAndPort string
+	sniHost     string
+	client      *dns.Client
+	retrier     *tcpRetrier // only set (and only consulted) for UDP
+}
+
+func newDNSUpstream(proto, hostAndPort string, resolver *bootstrap.Resolver, disableTCPRetryOnTruncation bool) (*dnsUpstream, error) {
+	dialAddress, sniHost := hostAndPort, ""
+
+	if proto == TCPTLSProtoName {
+		resolved, host, err := resolveHostAndPort(resolver, hostAndPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve DoT upstream [address=%s]: %w", hostAndPort, err)
+		}
+
+		dialAddress, sniHost = resolved, host
+	}
+
+	c := &dns.Client{
+		Dialer: &net.Dialer{
+			Timeout: dialTimeout,
+		},
+		DialTimeout:  dialTimeout,
+		WriteTimeout: writeTimeout,
+		ReadTimeout:  readTimeout,
+		Net:          proto,
+	}
+
+	if proto == TCPTLSProtoName {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true, ServerName: sniHost}
+	}
+
+	upstream := &dnsUpstream{proto: proto, hostAndPort: dialAddress, sniHost: sniHost, client: c}
+	if proto == UDPProtoName {
+		upstream.retrier = newTCPRetrier(dialAddress, disableTCPRetryOnTruncation)
+	}
+
+	return upstream, nil
+}
+
+func (u *dnsUpstream) Exchange(_ context.Context, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	co, err := u.client.Dial(u.hostAndPort)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer co.Close()
+
+	// Upgrade connection to use randomized ClientHello for TCP-TLS connections
+	if u.proto == TCPTLSProtoName {
+		co.Conn = utls.UClient(co, &utls.Config{InsecureSkipVerify: true, ServerName: u.sniHost}, utls.HelloRandomized)
+	}
+
+	reply, rtt, err := u.client.ExchangeWithConn(msg, co)
+	if u.retrier != nil {
+		return u.retrier.retryIfTruncated(msg, reply, rtt, err)
+	}
+
+	return reply, rtt, err
+}
+
+func (u *dnsUpstream) Address() string { return u.hostAndPort }
+
+func (u *dnsUpstream) Close() error { return nil }
+
+// buildUpstreamAddress turns a (protocol, resolved nameserver) pair into the
+// URL-style address NewUpstream expects. getNameservers hands back a bare
+// "host:port" for udp/tcp/tcp-tls and for doq (the "quic://" prefix is
+// stripped off by parseExplicitEndpoint), and a full URL for an explicit DoH
+// endpoint. That URL is passed through as is; every other nameserver gets a
+// scheme glued back on (plus a "/dns-query" path for DoH).
+func buildUpstreamAddress(protocol, nameserver string) string {
+	switch protocol {
+	case TCPProtoName:
+		return "tcp://" + nameserver
+	case TCPTLSProtoName:
+		return "tls://" + nameserver
+	case DoQProtoName:
+		return "quic://" + nameserver
+	case DoHProtoName:
+		if parsed, ok := parseExplicitEndpoint(nameserver); ok {
+			return parsed
+		}
+
+		return "https://" + nameserver + "/dns-query"
+	default:
+		return "udp://" + nameserver
+	}
+}